@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// GeoInfo is the location/network metadata enriched for a single node IP.
+type GeoInfo struct {
+	Country string  `json:"country"`
+	City    string  `json:"city"`
+	Lat     float64 `json:"lat"`
+	Lon     float64 `json:"lon"`
+	ASN     uint    `json:"asn"`
+	ASOrg   string  `json:"asOrg"`
+}
+
+// GeoProvider resolves an IP address to GeoInfo.
+type GeoProvider interface {
+	Lookup(ip string) (*GeoInfo, error)
+}
+
+// geoProviderForName builds the GeoProvider selected by --geo-provider. A
+// "none" (or empty) name disables enrichment and returns a nil provider.
+func geoProviderForName(name, dbPath, asnDBPath, apiKey string) (GeoProvider, error) {
+	switch name {
+	case "none", "":
+		return nil, nil
+	case "maxmind":
+		return newMaxMindProvider(dbPath, asnDBPath)
+	case "ipinfo":
+		return newIPInfoProvider(apiKey), nil
+	default:
+		return nil, fmt.Errorf("unknown geo provider %q", name)
+	}
+}
+
+// maxMindProvider resolves IPs against local GeoLite2 mmdb files. The
+// GeoLite2-City db supplies country/city/lat/lon; ASN/ASOrg need the
+// separate GeoLite2-ASN db (--geo-asn-db) since MaxMind doesn't ship ASN
+// data in the City db. asnDB is nil when --geo-asn-db wasn't given, in
+// which case ASN/ASOrg are left at their zero value.
+type maxMindProvider struct {
+	db    *geoip2.Reader
+	asnDB *geoip2.Reader
+}
+
+func newMaxMindProvider(dbPath, asnDBPath string) (*maxMindProvider, error) {
+	if dbPath == "" {
+		return nil, fmt.Errorf("--geo-db is required for the maxmind provider")
+	}
+	db, err := geoip2.Open(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	p := &maxMindProvider{db: db}
+	if asnDBPath != "" {
+		asnDB, err := geoip2.Open(asnDBPath)
+		if err != nil {
+			return nil, err
+		}
+		p.asnDB = asnDB
+	}
+	return p, nil
+}
+
+func (p *maxMindProvider) Lookup(ip string) (*GeoInfo, error) {
+	parsed := net.ParseIP(ip)
+	record, err := p.db.City(parsed)
+	if err != nil {
+		return nil, err
+	}
+	geo := &GeoInfo{
+		Country: record.Country.Names["en"],
+		City:    record.City.Names["en"],
+		Lat:     record.Location.Latitude,
+		Lon:     record.Location.Longitude,
+	}
+	if p.asnDB != nil {
+		asn, err := p.asnDB.ASN(parsed)
+		if err != nil {
+			return nil, err
+		}
+		geo.ASN = asn.AutonomousSystemNumber
+		geo.ASOrg = asn.AutonomousSystemOrganization
+	}
+	return geo, nil
+}
+
+// ipInfoProvider resolves IPs against the ipinfo.io HTTP API.
+type ipInfoProvider struct {
+	apiKey string
+	client *http.Client
+}
+
+func newIPInfoProvider(apiKey string) *ipInfoProvider {
+	return &ipInfoProvider{apiKey: apiKey, client: &http.Client{}}
+}
+
+func (p *ipInfoProvider) Lookup(ip string) (*GeoInfo, error) {
+	url := fmt.Sprintf("https://ipinfo.io/%s/json", ip)
+	if p.apiKey != "" {
+		url += "?token=" + p.apiKey
+	}
+	resp, err := p.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var data struct {
+		Country string `json:"country"`
+		City    string `json:"city"`
+		Loc     string `json:"loc"`
+		Org     string `json:"org"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, err
+	}
+
+	geo := &GeoInfo{Country: data.Country, City: data.City}
+	if lat, lon, ok := strings.Cut(data.Loc, ","); ok {
+		geo.Lat, _ = strconv.ParseFloat(lat, 64)
+		geo.Lon, _ = strconv.ParseFloat(lon, 64)
+	}
+	if asn, org, ok := strings.Cut(data.Org, " "); ok {
+		geo.ASOrg = org
+		if n, err := strconv.ParseUint(strings.TrimPrefix(asn, "AS"), 10, 32); err == nil {
+			geo.ASN = uint(n)
+		}
+	}
+	return geo, nil
+}
+
+// diskCachingProvider wraps a GeoProvider with a JSON-file cache keyed by IP,
+// so repeated runs against the same cluster don't re-hit rate-limited APIs.
+type diskCachingProvider struct {
+	inner GeoProvider
+	path  string
+
+	mu    sync.Mutex
+	cache map[string]*GeoInfo
+}
+
+func newDiskCachingProvider(inner GeoProvider, path string) (*diskCachingProvider, error) {
+	p := &diskCachingProvider{inner: inner, path: path, cache: map[string]*GeoInfo{}}
+	if path == "" {
+		return p, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return p, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &p.cache); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *diskCachingProvider) Lookup(ip string) (*GeoInfo, error) {
+	p.mu.Lock()
+	if geo, ok := p.cache[ip]; ok {
+		p.mu.Unlock()
+		return geo, nil
+	}
+	p.mu.Unlock()
+
+	geo, err := p.inner.Lookup(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	p.cache[ip] = geo
+	err = p.persist()
+	p.mu.Unlock()
+	return geo, err
+}
+
+// persist writes the in-memory cache to disk. Callers must hold p.mu.
+func (p *diskCachingProvider) persist() error {
+	if p.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(p.cache)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(p.path, data, 0o644)
+}
+
+// enrichGeo resolves GeoInfo for every result using provider, skipping nodes
+// whose IP can't be resolved rather than failing the whole run.
+func enrichGeo(latency []ClusterLatency, provider GeoProvider) {
+	if provider == nil {
+		return
+	}
+	for i := range latency {
+		geo, err := provider.Lookup(latency[i].IP)
+		if err != nil {
+			continue
+		}
+		latency[i].Geo = geo
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// tpuQUICALPN is the ALPN protocol Solana validators negotiate for
+// transactions forwarded over TPU QUIC.
+const tpuQUICALPN = "solana-tpu"
+
+// SlotRange is an inclusive [Start, End] span of slots a leader is
+// scheduled for.
+type SlotRange struct {
+	Start uint64
+	End   uint64
+}
+
+// UpcomingLeader is a validator scheduled to lead one or more of the next
+// slots, along with the measured TPU QUIC handshake RTT used to reach it.
+type UpcomingLeader struct {
+	Pubkey      string
+	ClusterNode *ClusterNode
+	SlotRanges  []SlotRange
+	RTT         time.Duration
+	Err         error
+}
+
+func jsonRPCPost(url string, body string, out interface{}) error {
+	resp, err := http.Post(url, "application/json", bytes.NewBufferString(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+// getEpochInfo returns the cluster's current absolute slot.
+func getEpochInfo(url string) (uint64, error) {
+	var data struct {
+		Result struct {
+			AbsoluteSlot uint64 `json:"absoluteSlot"`
+		} `json:"result"`
+	}
+	if err := jsonRPCPost(url, `{"jsonrpc":"2.0","id":1,"method":"getEpochInfo"}`, &data); err != nil {
+		return 0, err
+	}
+	return data.Result.AbsoluteSlot, nil
+}
+
+// getSlotLeaders returns the leader pubkey for each of the limit slots
+// starting at startSlot.
+func getSlotLeaders(url string, startSlot uint64, limit uint) ([]string, error) {
+	var data struct {
+		Result []string `json:"result"`
+	}
+	body := fmt.Sprintf(`{"jsonrpc":"2.0","id":1,"method":"getSlotLeaders","params":[%d,%d]}`, startSlot, limit)
+	if err := jsonRPCPost(url, body, &data); err != nil {
+		return nil, err
+	}
+	return data.Result, nil
+}
+
+// slotsPerLeader is how many consecutive slots each leader rotation holds.
+const slotsPerLeader = 4
+
+// upcomingLeaders resolves the next n leaders starting at the cluster's
+// current slot, collapsing consecutive slots led by the same validator into
+// a single SlotRange. It looks n*slotsPerLeader slots ahead, so it returns
+// fewer than n leaders when a validator leads several rotations in that span.
+func upcomingLeaders(url string, n uint) ([]UpcomingLeader, error) {
+	currentSlot, err := getEpochInfo(url)
+	if err != nil {
+		return nil, err
+	}
+	slotLeaders, err := getSlotLeaders(url, currentSlot, n*slotsPerLeader)
+	if err != nil {
+		return nil, err
+	}
+
+	leaders := []UpcomingLeader{}
+	byPubkey := map[string]int{}
+	for i, pubkey := range slotLeaders {
+		slot := currentSlot + uint64(i)
+		if idx, ok := byPubkey[pubkey]; ok {
+			ranges := leaders[idx].SlotRanges
+			if ranges[len(ranges)-1].End == slot-1 {
+				ranges[len(ranges)-1].End = slot
+			} else {
+				leaders[idx].SlotRanges = append(ranges, SlotRange{Start: slot, End: slot})
+			}
+			continue
+		}
+		if uint(len(leaders)) == n {
+			break
+		}
+		byPubkey[pubkey] = len(leaders)
+		leaders = append(leaders, UpcomingLeader{Pubkey: pubkey, SlotRanges: []SlotRange{{Start: slot, End: slot}}})
+	}
+	return leaders, nil
+}
+
+// measureLeaderLatency fills in ClusterNode and RTT for each leader by
+// matching against nodes and probing a TPU QUIC handshake.
+func measureLeaderLatency(leaders []UpcomingLeader, nodes *ClusterNodes, timeout time.Duration) []UpcomingLeader {
+	byPubkey := map[string]*ClusterNode{}
+	for i := range nodes.Result {
+		byPubkey[nodes.Result[i].Pubkey] = &nodes.Result[i]
+	}
+
+	for i := range leaders {
+		node, ok := byPubkey[leaders[i].Pubkey]
+		if !ok {
+			leaders[i].Err = fmt.Errorf("leader %s not present in getClusterNodes", leaders[i].Pubkey)
+			continue
+		}
+		leaders[i].ClusterNode = node
+		rtt, err := probeTPUQUICHandshake(node.Tpu, timeout)
+		if err != nil {
+			leaders[i].Err = err
+			continue
+		}
+		leaders[i].RTT = rtt
+	}
+	return leaders
+}
+
+// probeTPUQUICHandshake measures the time to complete a QUIC handshake
+// against a validator's TPU address, which is what a transaction-sending
+// client actually pays before it can forward a transaction.
+func probeTPUQUICHandshake(addr string, timeout time.Duration) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	conn, err := quic.DialAddr(ctx, addr, &tls.Config{InsecureSkipVerify: true, NextProtos: []string{tpuQUICALPN}}, nil)
+	if err != nil {
+		return 0, err
+	}
+	rtt := time.Since(start)
+	conn.CloseWithError(0, "")
+	return rtt, nil
+}
+
+func printLeaderSchedule(w io.Writer, leaders []UpcomingLeader) {
+	sort.Slice(leaders, func(i, j int) bool { return leaders[i].SlotRanges[0].Start < leaders[j].SlotRanges[0].Start })
+	for _, leader := range leaders {
+		ranges := make([]string, len(leader.SlotRanges))
+		for i, r := range leader.SlotRanges {
+			ranges[i] = fmt.Sprintf("%d-%d", r.Start, r.End)
+		}
+		if leader.Err != nil {
+			fmt.Fprintf(w, "pubkey:%s, slots:%v, unreachable: %v\n", leader.Pubkey, ranges, leader.Err)
+			continue
+		}
+		fmt.Fprintf(w, "pubkey:%s, slots:%v, tpu:%s, rtt:%dms\n", leader.Pubkey, ranges, leader.ClusterNode.Tpu, leader.RTT.Milliseconds())
+	}
+}
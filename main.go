@@ -3,19 +3,17 @@ package main
 import (
 	"bytes"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
-	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/cheggaaa/pb/v3"
-	"github.com/go-ping/ping"
 	"github.com/urfave/cli/v2"
 	"golang.org/x/sync/errgroup"
 )
@@ -40,6 +38,71 @@ func _main() error {
 			Usage: "top",
 			Value: 10,
 		},
+		&cli.StringFlag{
+			Name:  "probe",
+			Usage: "latency probe method: icmp, tcp, http, or rpc",
+			Value: string(ProbeICMP),
+		},
+		&cli.StringFlag{
+			Name:  "output",
+			Usage: "output format: table, json, csv, or prom",
+			Value: "table",
+		},
+		&cli.StringFlag{
+			Name:  "output-file",
+			Usage: "write output to this path instead of stdout",
+		},
+		&cli.StringFlag{
+			Name:  "geo-provider",
+			Usage: "geo enrichment provider: maxmind, ipinfo, or none",
+			Value: "none",
+		},
+		&cli.StringFlag{
+			Name:  "geo-db",
+			Usage: "path to a GeoLite2-City mmdb file (maxmind provider)",
+		},
+		&cli.StringFlag{
+			Name:  "geo-asn-db",
+			Usage: "path to a GeoLite2-ASN mmdb file (maxmind provider, optional; enables ASN/ASOrg enrichment)",
+		},
+		&cli.StringFlag{
+			Name:  "geo-api-key",
+			Usage: "API key for the ipinfo provider",
+		},
+		&cli.StringFlag{
+			Name:  "geo-cache",
+			Usage: "path to a disk cache file for geo lookups, keyed by IP",
+			Value: "geo-cache.json",
+		},
+		&cli.UintFlag{
+			Name:  "concurrency",
+			Usage: "number of nodes probed at once",
+			Value: defaultConcurrency,
+		},
+		&cli.UintFlag{
+			Name:  "probe-count",
+			Usage: "number of samples per probe",
+			Value: uint(DefaultProbeConfig.Count),
+		},
+		&cli.DurationFlag{
+			Name:  "probe-interval",
+			Usage: "delay between probe samples",
+			Value: DefaultProbeConfig.Interval,
+		},
+		&cli.DurationFlag{
+			Name:  "probe-timeout",
+			Usage: "timeout for a single probe sample",
+			Value: DefaultProbeConfig.Timeout,
+		},
+		&cli.BoolFlag{
+			Name:  "leader-schedule",
+			Usage: "only measure TPU QUIC handshake latency to the next --leader-count upcoming leaders",
+		},
+		&cli.UintFlag{
+			Name:  "leader-count",
+			Usage: "number of upcoming leaders to measure with --leader-schedule",
+			Value: 20,
+		},
 	}
 	app.Action = func(c *cli.Context) error {
 		url, err := buildURL(c.String("url"))
@@ -50,12 +113,110 @@ func _main() error {
 		if err != nil {
 			return err
 		}
-		latency, err := checkLatency(nodes)
+		if c.Bool("leader-schedule") {
+			leaders, err := upcomingLeaders(url, c.Uint("leader-count"))
+			if err != nil {
+				return err
+			}
+			leaders = measureLeaderLatency(leaders, nodes, c.Duration("probe-timeout"))
+			printLeaderSchedule(os.Stdout, leaders)
+			return nil
+		}
+		probeCfg := ProbeConfig{
+			Count:    int(c.Uint("probe-count")),
+			Interval: c.Duration("probe-interval"),
+			Timeout:  c.Duration("probe-timeout"),
+		}
+		latency, unreachable, err := checkLatency(nodes, ProbeType(c.String("probe")), probeCfg, int(c.Uint("concurrency")), true)
+		if err != nil {
+			return err
+		}
+		if len(unreachable) > 0 {
+			log.Printf("%d node(s) unreachable and excluded from results", len(unreachable))
+		}
+		geoProvider, err := geoProviderForName(c.String("geo-provider"), c.String("geo-db"), c.String("geo-asn-db"), c.String("geo-api-key"))
+		if err != nil {
+			return err
+		}
+		if geoProvider != nil {
+			geoProvider, err = newDiskCachingProvider(geoProvider, c.String("geo-cache"))
+			if err != nil {
+				return err
+			}
+		}
+		enrichGeo(latency, geoProvider)
+		writer, err := outputWriterForFormat(c.String("output"))
+		if err != nil {
+			return err
+		}
+		out, closeOut, err := openOutput(c.String("output-file"))
 		if err != nil {
 			return err
 		}
-		printResult(latency, c.Uint("top"))
-		return nil
+		defer closeOut()
+		return writer.Write(out, latency, c.Uint("top"))
+	}
+	app.Commands = []*cli.Command{
+		{
+			Name:  "serve",
+			Usage: "continuously track cluster latency and expose it on a Prometheus /metrics endpoint",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "url",
+					Usage: "url",
+					Value: "mainnet-beta",
+				},
+				&cli.DurationFlag{
+					Name:  "interval",
+					Usage: "interval between getClusterNodes/latency refreshes",
+					Value: time.Minute,
+				},
+				&cli.StringFlag{
+					Name:  "listen",
+					Usage: "address to serve /metrics on",
+					Value: ":9100",
+				},
+				&cli.StringFlag{
+					Name:  "probe",
+					Usage: "latency probe method: icmp, tcp, http, or rpc",
+					Value: string(ProbeICMP),
+				},
+				&cli.UintFlag{
+					Name:  "concurrency",
+					Usage: "number of nodes probed at once",
+					Value: defaultConcurrency,
+				},
+				&cli.UintFlag{
+					Name:  "probe-count",
+					Usage: "number of samples per probe",
+					Value: uint(DefaultProbeConfig.Count),
+				},
+				&cli.DurationFlag{
+					Name:  "probe-interval",
+					Usage: "delay between probe samples",
+					Value: DefaultProbeConfig.Interval,
+				},
+				&cli.DurationFlag{
+					Name:  "probe-timeout",
+					Usage: "timeout for a single probe sample",
+					Value: DefaultProbeConfig.Timeout,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				url, err := buildURL(c.String("url"))
+				if err != nil {
+					return err
+				}
+				probeCfg := ProbeConfig{
+					Count:    int(c.Uint("probe-count")),
+					Interval: c.Duration("probe-interval"),
+					Timeout:  c.Duration("probe-timeout"),
+				}
+				tracker := NewTracker(url, c.Duration("interval"), ProbeType(c.String("probe")), probeCfg, int(c.Uint("concurrency")))
+				go tracker.Run()
+				return tracker.ServeMetrics(c.String("listen"))
+			},
+		},
 	}
 	return app.Run(os.Args)
 }
@@ -66,8 +227,23 @@ type ClusterNodes struct {
 
 type ClusterLatency struct {
 	Time        time.Duration
+	Min         time.Duration
+	Max         time.Duration
+	PacketLoss  float64
+	StdDevRtt   time.Duration
 	IP          string
 	ClusterNode *ClusterNode
+	Geo         *GeoInfo
+}
+
+// NodeError records a cluster node that a probe could not reach.
+type NodeError struct {
+	ClusterNode *ClusterNode
+	Err         error
+}
+
+func (e NodeError) Error() string {
+	return fmt.Sprintf("%s: %v", e.ClusterNode.Pubkey, e.Err)
 }
 
 type ClusterNode struct {
@@ -114,56 +290,75 @@ func getClusterNodes(url string) (*ClusterNodes, error) {
 	return &data, nil
 }
 
-const concurrency = 20
+// defaultConcurrency is how many nodes are probed at once when --concurrency
+// isn't given.
+const defaultConcurrency = 20
 
-func checkLatency(clusterNodes *ClusterNodes) ([]ClusterLatency, error) {
+// checkLatency probes every node in clusterNodes and returns the reachable
+// results plus the ones that errored out. When showProgress is set (the
+// one-shot CLI path), a CLI progress bar is rendered to stderr as probes
+// complete; the serve daemon passes false so its periodic refreshes don't
+// spam the log with a fresh bar every cycle.
+func checkLatency(clusterNodes *ClusterNodes, probeType ProbeType, cfg ProbeConfig, concurrency int, showProgress bool) ([]ClusterLatency, []NodeError, error) {
+	probe, err := probeForType(probeType)
+	if err != nil {
+		return nil, nil, err
+	}
+	if concurrency <= 0 {
+		concurrency = defaultConcurrency
+	}
+
+	var mu sync.Mutex
 	result := []ClusterLatency{}
+	unreachable := []NodeError{}
 	sem := make(chan struct{}, concurrency)
 	eg := errgroup.Group{}
-	bar := pb.Simple.Start(len(clusterNodes.Result))
-	bar.SetMaxWidth(80)
+	var bar *pb.ProgressBar
+	if showProgress {
+		bar = pb.Simple.Start(len(clusterNodes.Result))
+		bar.SetMaxWidth(80)
+	}
 	for _, node := range clusterNodes.Result {
 		sem <- struct{}{}
 		node := node
 		eg.Go(func() error {
 			defer func() { <-sem }()
-			split := strings.Split(node.Gossip, ":")
-			if len(split) != 2 {
-				return errors.New("invalid gossip ip")
+			if bar != nil {
+				defer bar.Increment()
 			}
-			pinger, err := ping.NewPinger(split[0])
-			if err != nil {
-				return err
-			}
-			pinger.Count = 5
-			pinger.Interval = 200 * time.Millisecond
-			pinger.Timeout = 3 * time.Second
 
-			if err := pinger.Run(); err != nil {
-				return err
+			ip := node.Gossip
+			if split := strings.Split(node.Gossip, ":"); len(split) == 2 {
+				ip = split[0]
 			}
-			pingResult := pinger.Statistics()
-			if pingResult.PacketLoss == 0 {
-				result = append(result, ClusterLatency{Time: pingResult.AvgRtt, IP: split[0], ClusterNode: &node})
+
+			stats, err := probe(&node, cfg)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				unreachable = append(unreachable, NodeError{ClusterNode: &node, Err: err})
+				return nil
 			}
-			bar.Increment()
+			result = append(result, ClusterLatency{
+				Time:        stats.Avg,
+				Min:         stats.Min,
+				Max:         stats.Max,
+				StdDevRtt:   stats.StdDev,
+				PacketLoss:  stats.PacketLoss,
+				IP:          ip,
+				ClusterNode: &node,
+			})
 			return nil
 		})
 	}
-	bar.Finish()
-	if err := eg.Wait(); err != nil {
-		return nil, err
-	}
-	return result, nil
-}
-
-func printResult(latency []ClusterLatency, top uint) {
-	sort.Slice(latency, func(i, j int) bool { return latency[i].Time < latency[j].Time })
-	count := int(top)
-	if len(latency) < 10 {
-		count = len(latency)
+	err = eg.Wait()
+	if bar != nil {
+		bar.Finish()
 	}
-	for i := 0; i < count; i++ {
-		fmt.Printf("[%d] time:%dms, ip:%s, pubkey:%s\n", i, latency[i].Time.Milliseconds(), latency[i].IP, latency[i].ClusterNode.Pubkey)
+	if err != nil {
+		return nil, nil, err
 	}
+	return result, unreachable, nil
 }
+
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// openOutput returns a writer for path, or os.Stdout if path is empty, along
+// with a close func that must always be called.
+func openOutput(path string) (io.Writer, func(), error) {
+	if path == "" {
+		return os.Stdout, func() {}, nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, func() { f.Close() }, nil
+}
+
+// resultRow is the full, flat set of fields reported for a single node,
+// used by every OutputWriter so formats stay in sync with each other.
+type resultRow struct {
+	Pubkey       string  `json:"pubkey"`
+	IP           string  `json:"ip"`
+	Gossip       string  `json:"gossip"`
+	Tpu          string  `json:"tpu"`
+	Rpc          string  `json:"rpc"`
+	Version      string  `json:"version"`
+	FeatureSet   uint    `json:"featureSet"`
+	ShredVersion uint    `json:"shredVersion"`
+	AvgRttMs     float64 `json:"avgRttMs"`
+	MinRttMs     float64 `json:"minRttMs"`
+	MaxRttMs     float64 `json:"maxRttMs"`
+	StdDevRttMs  float64 `json:"stddevRttMs"`
+	PacketLoss   float64 `json:"packetLoss"`
+	Country      string  `json:"country,omitempty"`
+	City         string  `json:"city,omitempty"`
+	Lat          float64 `json:"lat,omitempty"`
+	Lon          float64 `json:"lon,omitempty"`
+	ASN          uint    `json:"asn,omitempty"`
+	ASOrg        string  `json:"asOrg,omitempty"`
+}
+
+func msOf(d interface{ Microseconds() int64 }) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+func sortAndTruncate(latency []ClusterLatency, top uint) []ClusterLatency {
+	sort.Slice(latency, func(i, j int) bool { return latency[i].Time < latency[j].Time })
+	count := int(top)
+	if count > len(latency) {
+		count = len(latency)
+	}
+	return latency[:count]
+}
+
+func rowsOf(latency []ClusterLatency) []resultRow {
+	rows := make([]resultRow, len(latency))
+	for i, l := range latency {
+		rows[i] = resultRow{
+			Pubkey:       l.ClusterNode.Pubkey,
+			IP:           l.IP,
+			Gossip:       l.ClusterNode.Gossip,
+			Tpu:          l.ClusterNode.Tpu,
+			Rpc:          l.ClusterNode.Rpc,
+			Version:      l.ClusterNode.Version,
+			FeatureSet:   l.ClusterNode.FeatureSet,
+			ShredVersion: l.ClusterNode.ShredVersion,
+			AvgRttMs:     msOf(l.Time),
+			MinRttMs:     msOf(l.Min),
+			MaxRttMs:     msOf(l.Max),
+			StdDevRttMs:  msOf(l.StdDevRtt),
+			PacketLoss:   l.PacketLoss,
+		}
+		if l.Geo != nil {
+			rows[i].Country = l.Geo.Country
+			rows[i].City = l.Geo.City
+			rows[i].Lat = l.Geo.Lat
+			rows[i].Lon = l.Geo.Lon
+			rows[i].ASN = l.Geo.ASN
+			rows[i].ASOrg = l.Geo.ASOrg
+		}
+	}
+	return rows
+}
+
+// groupByRegion buckets rows by country for table output, so results for
+// the same geography are printed together. Rows without geo info land in a
+// single "" bucket. Buckets are printed in alphabetical order by the
+// caller, not in order of first appearance.
+func groupByRegion(rows []resultRow) map[string][]resultRow {
+	groups := map[string][]resultRow{}
+	for _, row := range rows {
+		groups[row.Country] = append(groups[row.Country], row)
+	}
+	return groups
+}
+
+// OutputWriter renders the top-N latency results in a specific format.
+type OutputWriter interface {
+	Write(w io.Writer, latency []ClusterLatency, top uint) error
+}
+
+func outputWriterForFormat(format string) (OutputWriter, error) {
+	switch format {
+	case "table", "":
+		return tableOutputWriter{}, nil
+	case "json":
+		return jsonOutputWriter{}, nil
+	case "csv":
+		return csvOutputWriter{}, nil
+	case "prom":
+		return promOutputWriter{}, nil
+	default:
+		return nil, fmt.Errorf("unknown output format %q", format)
+	}
+}
+
+type tableOutputWriter struct{}
+
+func (tableOutputWriter) Write(w io.Writer, latency []ClusterLatency, top uint) error {
+	rows := rowsOf(sortAndTruncate(latency, top))
+
+	hasGeo := false
+	for _, row := range rows {
+		if row.Country != "" {
+			hasGeo = true
+			break
+		}
+	}
+	if !hasGeo {
+		return writeRows(w, rows)
+	}
+
+	groups := groupByRegion(rows)
+	regions := make([]string, 0, len(groups))
+	for region := range groups {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+	for _, region := range regions {
+		label := region
+		if label == "" {
+			label = "unknown"
+		}
+		if _, err := fmt.Fprintf(w, "== %s ==\n", label); err != nil {
+			return err
+		}
+		if err := writeRows(w, groups[region]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeRows(w io.Writer, rows []resultRow) error {
+	for i, row := range rows {
+		if _, err := fmt.Fprintf(w,
+			"[%d] pubkey:%s, gossip:%s, tpu:%s, rpc:%s, version:%s, featureSet:%d, shredVersion:%d, avg:%.2fms, min:%.2fms, max:%.2fms, stddev:%.2fms, loss:%.0f%%, city:%s, asn:AS%d %s\n",
+			i, row.Pubkey, row.Gossip, row.Tpu, row.Rpc, row.Version, row.FeatureSet, row.ShredVersion,
+			row.AvgRttMs, row.MinRttMs, row.MaxRttMs, row.StdDevRttMs, row.PacketLoss*100, row.City, row.ASN, row.ASOrg,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type jsonOutputWriter struct{}
+
+func (jsonOutputWriter) Write(w io.Writer, latency []ClusterLatency, top uint) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rowsOf(sortAndTruncate(latency, top)))
+}
+
+type csvOutputWriter struct{}
+
+func (csvOutputWriter) Write(w io.Writer, latency []ClusterLatency, top uint) error {
+	cw := csv.NewWriter(w)
+	header := []string{
+		"pubkey", "gossip", "tpu", "rpc", "version", "featureSet", "shredVersion",
+		"avgRttMs", "minRttMs", "maxRttMs", "stddevRttMs", "packetLoss",
+		"country", "city", "lat", "lon", "asn", "asOrg",
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, row := range rowsOf(sortAndTruncate(latency, top)) {
+		record := []string{
+			row.Pubkey, row.Gossip, row.Tpu, row.Rpc, row.Version,
+			strconv.FormatUint(uint64(row.FeatureSet), 10),
+			strconv.FormatUint(uint64(row.ShredVersion), 10),
+			strconv.FormatFloat(row.AvgRttMs, 'f', 2, 64),
+			strconv.FormatFloat(row.MinRttMs, 'f', 2, 64),
+			strconv.FormatFloat(row.MaxRttMs, 'f', 2, 64),
+			strconv.FormatFloat(row.StdDevRttMs, 'f', 2, 64),
+			strconv.FormatFloat(row.PacketLoss, 'f', 4, 64),
+			row.Country, row.City,
+			strconv.FormatFloat(row.Lat, 'f', 4, 64),
+			strconv.FormatFloat(row.Lon, 'f', 4, 64),
+			strconv.FormatUint(uint64(row.ASN), 10),
+			row.ASOrg,
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// promOutputWriter renders results as a Prometheus textfile-collector file,
+// suitable for node_exporter's --collector.textfile.directory.
+type promOutputWriter struct{}
+
+func (promOutputWriter) Write(w io.Writer, latency []ClusterLatency, top uint) error {
+	metrics := []struct {
+		name string
+		help string
+		val  func(resultRow) float64
+	}{
+		{"solana_validator_rtt_ms", "Average round-trip time to a validator, in milliseconds", func(r resultRow) float64 { return r.AvgRttMs }},
+		{"solana_validator_rtt_min_ms", "Minimum round-trip time to a validator, in milliseconds", func(r resultRow) float64 { return r.MinRttMs }},
+		{"solana_validator_rtt_max_ms", "Maximum round-trip time to a validator, in milliseconds", func(r resultRow) float64 { return r.MaxRttMs }},
+		{"solana_validator_rtt_stddev_ms", "Standard deviation of round-trip time to a validator, in milliseconds", func(r resultRow) float64 { return r.StdDevRttMs }},
+		{"solana_validator_packet_loss_ratio", "Fraction of probes lost when reaching a validator", func(r resultRow) float64 { return r.PacketLoss }},
+	}
+
+	rows := rowsOf(sortAndTruncate(latency, top))
+	for _, m := range metrics {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", m.name, m.help, m.name); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if _, err := fmt.Fprintf(w, "%s{pubkey=%q,ip=%q,version=%q,shred_version=%q} %v\n",
+				m.name, row.Pubkey, row.IP, row.Version, strconv.FormatUint(uint64(row.ShredVersion), 10), m.val(row),
+			); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
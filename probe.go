@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-ping/ping"
+)
+
+var errInvalidGossipIP = errors.New("invalid gossip ip")
+
+// ProbeType selects how latency to a cluster node is measured.
+type ProbeType string
+
+const (
+	ProbeICMP ProbeType = "icmp"
+	ProbeTCP  ProbeType = "tcp"
+	ProbeHTTP ProbeType = "http"
+	ProbeRPC  ProbeType = "rpc"
+)
+
+// ProbeConfig controls sample count, spacing, and per-sample timeout shared
+// by every probe implementation.
+type ProbeConfig struct {
+	Count    int
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+// DefaultProbeConfig matches the sampling behaviour this tool has always used.
+var DefaultProbeConfig = ProbeConfig{
+	Count:    5,
+	Interval: 200 * time.Millisecond,
+	Timeout:  3 * time.Second,
+}
+
+// probeStats holds the min/avg/max/stddev RTT observed over a probe's
+// samples, plus the fraction of samples lost (0..1). Only probeICMP can
+// observe partial loss; the other probes treat any failed sample as the
+// whole probe being unreachable, so they always report 0.
+type probeStats struct {
+	Avg        time.Duration
+	Min        time.Duration
+	Max        time.Duration
+	StdDev     time.Duration
+	PacketLoss float64
+}
+
+// probeFunc measures latency to a single cluster node. An error indicates
+// the node is unreachable by this probe method.
+type probeFunc func(node *ClusterNode, cfg ProbeConfig) (probeStats, error)
+
+func probeForType(t ProbeType) (probeFunc, error) {
+	switch t {
+	case ProbeICMP, "":
+		return probeICMP, nil
+	case ProbeTCP:
+		return probeTCP, nil
+	case ProbeHTTP:
+		return probeHTTP, nil
+	case ProbeRPC:
+		return probeRPC, nil
+	default:
+		return nil, fmt.Errorf("unknown probe type %q", t)
+	}
+}
+
+// rpcEndpoint builds the HTTP URL for a node's Rpc address. Unlike the
+// top-level --url flag, node.Rpc is always a bare "host:port" pair as
+// reported by getClusterNodes, so url.Parse/buildURL's moniker handling
+// doesn't apply here.
+func rpcEndpoint(node *ClusterNode) string {
+	return "http://" + node.Rpc
+}
+
+func statsFromSamples(samples []time.Duration) probeStats {
+	stats := probeStats{Min: samples[0], Max: samples[0]}
+	var sum time.Duration
+	for _, s := range samples {
+		sum += s
+		if s < stats.Min {
+			stats.Min = s
+		}
+		if s > stats.Max {
+			stats.Max = s
+		}
+	}
+	stats.Avg = sum / time.Duration(len(samples))
+
+	var variance float64
+	for _, s := range samples {
+		d := float64(s - stats.Avg)
+		variance += d * d
+	}
+	variance /= float64(len(samples))
+	stats.StdDev = time.Duration(math.Sqrt(variance))
+	return stats
+}
+
+func probeICMP(node *ClusterNode, cfg ProbeConfig) (probeStats, error) {
+	if cfg.Count < 1 {
+		return probeStats{}, fmt.Errorf("probe count must be >= 1, got %d", cfg.Count)
+	}
+	split := strings.Split(node.Gossip, ":")
+	if len(split) != 2 {
+		return probeStats{}, errInvalidGossipIP
+	}
+	pinger, err := ping.NewPinger(split[0])
+	if err != nil {
+		return probeStats{}, err
+	}
+	pinger.Count = cfg.Count
+	pinger.Interval = cfg.Interval
+	pinger.Timeout = cfg.Timeout
+	if err := pinger.Run(); err != nil {
+		return probeStats{}, err
+	}
+	result := pinger.Statistics()
+	if result.PacketLoss >= 100 {
+		return probeStats{}, fmt.Errorf("100%% packet loss")
+	}
+	return probeStats{
+		Avg: result.AvgRtt, Min: result.MinRtt, Max: result.MaxRtt, StdDev: result.StdDevRtt,
+		PacketLoss: result.PacketLoss / 100,
+	}, nil
+}
+
+func probeTCP(node *ClusterNode, cfg ProbeConfig) (probeStats, error) {
+	return sampleDuration(cfg, func() (time.Duration, error) {
+		start := time.Now()
+		conn, err := net.DialTimeout("tcp", node.Tpu, cfg.Timeout)
+		if err != nil {
+			return 0, err
+		}
+		conn.Close()
+		return time.Since(start), nil
+	})
+}
+
+func probeHTTP(node *ClusterNode, cfg ProbeConfig) (probeStats, error) {
+	rpcURL := rpcEndpoint(node)
+	client := &http.Client{Timeout: cfg.Timeout}
+	return sampleDuration(cfg, func() (time.Duration, error) {
+		start := time.Now()
+		resp, err := client.Head(rpcURL)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return time.Since(start), nil
+	})
+}
+
+func probeRPC(node *ClusterNode, cfg ProbeConfig) (probeStats, error) {
+	rpcURL := rpcEndpoint(node)
+	client := &http.Client{Timeout: cfg.Timeout}
+	return sampleDuration(cfg, func() (time.Duration, error) {
+		start := time.Now()
+		resp, err := client.Post(rpcURL, "application/json", bytes.NewBufferString(`{"jsonrpc":"2.0","id":1,"method":"getHealth"}`))
+		if err != nil {
+			return 0, err
+		}
+		defer resp.Body.Close()
+		if _, err := ioutil.ReadAll(resp.Body); err != nil {
+			return 0, err
+		}
+		return time.Since(start), nil
+	})
+}
+
+// sampleDuration runs sample cfg.Count times, spaced cfg.Interval apart, and
+// aggregates the results into a probeStats. The first error aborts the
+// probe and the node is reported unreachable; unlike the ICMP probe, a
+// partial loss is not tolerated.
+func sampleDuration(cfg ProbeConfig, sample func() (time.Duration, error)) (probeStats, error) {
+	if cfg.Count < 1 {
+		return probeStats{}, fmt.Errorf("probe count must be >= 1, got %d", cfg.Count)
+	}
+	samples := make([]time.Duration, 0, cfg.Count)
+	for i := 0; i < cfg.Count; i++ {
+		if i > 0 {
+			time.Sleep(cfg.Interval)
+		}
+		d, err := sample()
+		if err != nil {
+			return probeStats{}, err
+		}
+		samples = append(samples, d)
+	}
+	return statsFromSamples(samples), nil
+}
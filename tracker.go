@@ -0,0 +1,141 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Tracker periodically fetches the cluster node set from an RPC endpoint
+// and re-measures latency against it, similar to the polling pattern used
+// by tpuproxy's clusternodes package. The latest node set is kept in memory
+// and the latest latency results are set on Prometheus gauges for scraping.
+type Tracker struct {
+	url         string
+	interval    time.Duration
+	probeType   ProbeType
+	probeCfg    ProbeConfig
+	concurrency int
+
+	mu      sync.RWMutex
+	nodes   map[string]*ClusterNode
+	updated time.Time
+
+	rtt        *prometheus.GaugeVec
+	packetLoss *prometheus.GaugeVec
+	jitter     *prometheus.GaugeVec
+	lastUpdate prometheus.Gauge
+}
+
+// NewTracker creates a Tracker that polls url every interval using probeType.
+func NewTracker(url string, interval time.Duration, probeType ProbeType, probeCfg ProbeConfig, concurrency int) *Tracker {
+	return &Tracker{
+		url:         url,
+		interval:    interval,
+		probeType:   probeType,
+		probeCfg:    probeCfg,
+		concurrency: concurrency,
+		nodes:       map[string]*ClusterNode{},
+		rtt: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "solana_validator_rtt_ms",
+			Help: "Average round-trip time to a validator, in milliseconds",
+		}, []string{"pubkey", "ip", "version", "shred_version"}),
+		packetLoss: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "solana_validator_packet_loss_ratio",
+			Help: "Fraction of probes lost when reaching a validator",
+		}, []string{"pubkey", "ip", "version", "shred_version"}),
+		jitter: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "solana_validator_rtt_stddev_ms",
+			Help: "Standard deviation of round-trip time to a validator, in milliseconds",
+		}, []string{"pubkey", "ip", "version", "shred_version"}),
+		lastUpdate: promauto.NewGauge(prometheus.GaugeOpts{
+			Name: "solana_tracker_last_update_timestamp_seconds",
+			Help: "Unix timestamp of the last successful latency refresh",
+		}),
+	}
+}
+
+// Run refreshes the latency table every interval until the process exits.
+// Errors are logged and the tracker keeps serving the last known good data.
+func (t *Tracker) Run() {
+	for {
+		if err := t.refresh(); err != nil {
+			log.Printf("tracker: refresh failed: %v", err)
+		}
+		time.Sleep(t.interval)
+	}
+}
+
+func (t *Tracker) refresh() error {
+	clusterNodes, err := getClusterNodes(t.url)
+	if err != nil {
+		return err
+	}
+	latency, unreachable, err := checkLatency(clusterNodes, t.probeType, t.probeCfg, t.concurrency, false)
+	if err != nil {
+		return err
+	}
+	if len(unreachable) > 0 {
+		log.Printf("tracker: %d node(s) unreachable this cycle", len(unreachable))
+	}
+
+	t.mu.Lock()
+	prevNodes := t.nodes
+	nodes := make(map[string]*ClusterNode, len(clusterNodes.Result))
+	for i := range clusterNodes.Result {
+		nodes[clusterNodes.Result[i].Pubkey] = &clusterNodes.Result[i]
+	}
+	t.nodes = nodes
+	t.updated = time.Now()
+	t.mu.Unlock()
+	logNodeChurn(prevNodes, nodes)
+
+	t.rtt.Reset()
+	t.packetLoss.Reset()
+	t.jitter.Reset()
+	for _, l := range latency {
+		labels := prometheus.Labels{
+			"pubkey":        l.ClusterNode.Pubkey,
+			"ip":            l.IP,
+			"version":       l.ClusterNode.Version,
+			"shred_version": strconv.FormatUint(uint64(l.ClusterNode.ShredVersion), 10),
+		}
+		t.rtt.With(labels).Set(float64(l.Time.Microseconds()) / 1000)
+		t.packetLoss.With(labels).Set(l.PacketLoss)
+		t.jitter.With(labels).Set(float64(l.StdDevRtt.Microseconds()) / 1000)
+	}
+	t.lastUpdate.Set(float64(t.updated.Unix()))
+	return nil
+}
+
+// logNodeChurn logs any pubkey that joined or left the cluster between two
+// refreshes of Tracker.nodes. prev is empty on the first refresh, which is
+// treated as "nothing to compare" rather than every node joining at once.
+func logNodeChurn(prev, next map[string]*ClusterNode) {
+	if len(prev) == 0 {
+		return
+	}
+	for pubkey := range next {
+		if _, ok := prev[pubkey]; !ok {
+			log.Printf("tracker: node %s joined the cluster", pubkey)
+		}
+	}
+	for pubkey := range prev {
+		if _, ok := next[pubkey]; !ok {
+			log.Printf("tracker: node %s left the cluster", pubkey)
+		}
+	}
+}
+
+// ServeMetrics blocks serving the /metrics endpoint on addr.
+func (t *Tracker) ServeMetrics(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	return http.ListenAndServe(addr, mux)
+}